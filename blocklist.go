@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Supported BlockingConfig.BlockType values.
+const (
+	blockTypeNXDomain = "nxdomain" // default
+	blockTypeZeroIP   = "zeroip"
+	blockTypeCustomIP = "custom_ip"
+)
+
+const blocklistRefreshInterval = 24 * time.Hour
+
+// defaultBlockSOA is served in the Authority section of an nxdomain block
+// response when BlockingConfig.SOA isn't set, so nxdomain blocking produces
+// a spec-compliant answer (RFC 2308) without extra configuration.
+var defaultBlockSOA = &SOA{
+	Ns:      "blocklist.invalid.",
+	Mbox:    "admin.blocklist.invalid.",
+	Serial:  1,
+	Refresh: 3600,
+	Retry:   600,
+	Expire:  86400,
+	Minimum: 60,
+}
+
+// listSource caches the HTTP validators and last-parsed names for one list
+// URL so a refresh that gets a 304 doesn't have to re-download or drop the
+// names it already knows about.
+type listSource struct {
+	etag         string
+	lastModified string
+	names        []string
+}
+
+// blocklist holds the merged block/allow sets built from Config.Blocking and
+// refreshes them periodically. It is safe for concurrent use.
+type blocklist struct {
+	cfg BlockingConfig
+
+	mu      sync.RWMutex
+	blocked map[string]struct{}
+	allowed map[string]struct{}
+
+	sources map[string]*listSource
+}
+
+func newBlocklist(cfg BlockingConfig) *blocklist {
+	b := &blocklist{
+		cfg:     cfg,
+		blocked: map[string]struct{}{},
+		allowed: map[string]struct{}{},
+		sources: map[string]*listSource{},
+	}
+	if len(cfg.Lists) == 0 && len(cfg.Allowlists) == 0 {
+		return b
+	}
+	b.refresh()
+	go func() {
+		for {
+			time.Sleep(blocklistRefreshInterval)
+			b.refresh()
+		}
+	}()
+	return b
+}
+
+func (b *blocklist) refresh() {
+	blocked := map[string]struct{}{}
+	for _, list := range b.cfg.Lists {
+		names, err := b.fetchList(list)
+		if err != nil {
+			log.Println("blocklist: fetch", list, err)
+			continue
+		}
+		for _, n := range names {
+			blocked[n] = struct{}{}
+		}
+	}
+	allowed := map[string]struct{}{}
+	for _, list := range b.cfg.Allowlists {
+		names, err := b.fetchList(list)
+		if err != nil {
+			log.Println("blocklist: fetch allowlist", list, err)
+			continue
+		}
+		for _, n := range names {
+			allowed[n] = struct{}{}
+		}
+	}
+	b.mu.Lock()
+	b.blocked = blocked
+	b.allowed = allowed
+	b.mu.Unlock()
+	log.Println("blocklist: loaded", len(blocked), "blocked,", len(allowed), "allowed")
+}
+
+// fetchList downloads (or reads, for a local path) one list and parses it as
+// either hosts-file format ("0.0.0.0 example.com") or plain-domain format
+// ("example.com" per line), skipping comments and deduping names.
+func (b *blocklist) fetchList(list string) ([]string, error) {
+	if strings.HasPrefix(list, "http://") || strings.HasPrefix(list, "https://") {
+		return b.fetchRemoteList(list)
+	}
+	f, err := os.Open(list)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseHostsList(f)
+}
+
+func (b *blocklist) fetchRemoteList(list string) ([]string, error) {
+	src, ok := b.sources[list]
+	if !ok {
+		src = &listSource{}
+		b.sources[list] = src
+	}
+
+	req, err := http.NewRequest(http.MethodGet, list, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.etag != "" {
+		req.Header.Set("If-None-Match", src.etag)
+	}
+	if src.lastModified != "" {
+		req.Header.Set("If-Modified-Since", src.lastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return src.names, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	names, err := parseHostsList(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	src.etag = resp.Header.Get("ETag")
+	src.lastModified = resp.Header.Get("Last-Modified")
+	src.names = names
+	return names, nil
+}
+
+func parseHostsList(r io.Reader) ([]string, error) {
+	seen := map[string]struct{}{}
+	names := make([]string, 0, 1024)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		fields := strings.Fields(line)
+		name := fields[len(fields)-1]
+		if len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+			name = fields[1]
+		}
+		name = strings.ToLower(dns.Fqdn(name))
+		if name == "." || name == "localhost." {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	return names, scanner.Err()
+}
+
+// suffixChain returns name and every parent domain suffix as lowercase
+// FQDNs, e.g. "a.b.example.com." -> ["a.b.example.com.", "b.example.com.", "example.com.", "com."]
+func suffixChain(name string) []string {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	chain := make([]string, 0, len(labels))
+	for i := range labels {
+		chain = append(chain, dns.Fqdn(strings.Join(labels[i:], ".")))
+	}
+	return chain
+}
+
+// isBlocked reports whether name, or any parent suffix of it, is present in
+// the block set and not overridden by the allow set.
+func (b *blocklist) isBlocked(name string) bool {
+	if len(b.cfg.Lists) == 0 {
+		return false
+	}
+	chain := suffixChain(name)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, candidate := range chain {
+		if _, ok := b.allowed[candidate]; ok {
+			return false
+		}
+	}
+	for _, candidate := range chain {
+		if _, ok := b.blocked[candidate]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// soa returns the SOA to place in the Authority section of an nxdomain
+// block response, falling back to defaultBlockSOA if none is configured.
+func (b *blocklist) soa() *SOA {
+	if b.cfg.SOA != nil {
+		return b.cfg.SOA
+	}
+	return defaultBlockSOA
+}
+
+// respond synthesizes the configured zeroip/custom_ip answer for a blocked
+// query. BlockType nxdomain is handled by the caller via msg.Rcode instead.
+func (b *blocklist) respond(question dns.Question) []dns.RR {
+	ip := net.IPv4zero
+	if b.cfg.BlockType == blockTypeCustomIP {
+		if parsed := net.ParseIP(b.cfg.CustomIP); parsed != nil {
+			ip = parsed
+		}
+	}
+	if question.Qtype == dns.TypeAAAA {
+		if ip.To4() != nil && b.cfg.BlockType != blockTypeCustomIP {
+			ip = net.IPv6zero
+		}
+		return []dns.RR{&dns.AAAA{
+			Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+			AAAA: ip,
+		}}
+	}
+	return []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   ip,
+	}}
+}
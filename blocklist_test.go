@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHostsList(t *testing.T) {
+	input := `
+# comment line
+! adblock-style comment
+0.0.0.0 ads.example.com
+127.0.0.1 tracker.example.com
+plain-domain.example.com
+0.0.0.0 ads.example.com
+
+localhost
+`
+	names, err := parseHostsList(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseHostsList: %v", err)
+	}
+
+	want := []string{
+		"ads.example.com.",
+		"tracker.example.com.",
+		"plain-domain.example.com.",
+	}
+	if len(names) != len(want) {
+		t.Fatalf("parseHostsList = %v, want %v", names, want)
+	}
+	for i, name := range names {
+		if name != want[i] {
+			t.Errorf("names[%d] = %q, want %q", i, name, want[i])
+		}
+	}
+}
+
+func TestSuffixChain(t *testing.T) {
+	got := suffixChain("a.b.example.com")
+	want := []string{"a.b.example.com.", "b.example.com.", "example.com.", "com."}
+	if len(got) != len(want) {
+		t.Fatalf("suffixChain = %v, want %v", got, want)
+	}
+	for i, s := range got {
+		if s != want[i] {
+			t.Errorf("suffixChain[%d] = %q, want %q", i, s, want[i])
+		}
+	}
+}
+
+func TestIsBlocked(t *testing.T) {
+	b := &blocklist{
+		cfg:     BlockingConfig{Lists: []string{"dummy"}},
+		blocked: map[string]struct{}{"ads.example.com.": {}},
+		allowed: map[string]struct{}{"good.ads.example.com.": {}},
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"ads.example.com.", true},
+		{"sub.ads.example.com.", true},
+		{"good.ads.example.com.", false}, // allowlist overrides the parent block
+		{"other.example.com.", false},
+	}
+	for _, tt := range tests {
+		if got := b.isBlocked(tt.name); got != tt.want {
+			t.Errorf("isBlocked(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
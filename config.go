@@ -29,18 +29,74 @@ type Record struct {
 	Type       string `json:"type"`
 	Value      string `json:"value"`
 	TTL        uint32 `json:"ttl"`
-	Preference uint16 `json:"preference"`
+	Preference uint16 `json:"preference"` // MX
+	Priority   uint16 `json:"priority"`   // SRV
+	Weight     uint16 `json:"weight"`     // SRV
+	Port       uint16 `json:"port"`       // SRV
+	Target     string `json:"target"`     // SRV target host
+	Ns         string `json:"ns"`         // NS nameserver
+	Ptr        string `json:"ptr"`        // PTR target
+	Flag       uint8  `json:"flag"`       // CAA
+	Tag        string `json:"tag"`        // CAA, 例如 "issue"/"issuewild"/"iodef"
+	SOA        *SOA   `json:"soa"`        // SOA
+}
+
+// SOA is the synthetic authority record served for negative (NXDOMAIN/
+// NODATA) answers within a locally-defined Domain, per RFC 2308 §5.
+type SOA struct {
+	Ns      string `json:"ns"`
+	Mbox    string `json:"mbox"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"` // also used as the negative-cache TTL ceiling
 }
 
 type Domain struct {
 	Name    string   `json:"name"`
 	Records []Record `json:"records"`
+	SOA     *SOA     `json:"soa"`
+}
+
+// BlockingConfig configures the blocklist subsystem: domain lists to block,
+// domain lists that override them, and how a blocked query is answered.
+type BlockingConfig struct {
+	Lists      []string `json:"lists"`      // hosts 文件或纯域名列表, 支持 http(s):// URL 或本地路径
+	Allowlists []string `json:"allowlists"` // 格式同 Lists, 命中时优先于 Lists 放行
+	BlockType  string   `json:"block_type"` // nxdomain(默认) / zeroip / custom_ip
+	CustomIP   string   `json:"custom_ip"`  // BlockType 为 custom_ip 时使用
+	SOA        *SOA     `json:"soa"`        // BlockType 为 nxdomain 时放入 Authority 段, 留空则使用内置默认值
+}
+
+// QueryLogConfig configures the structured query log: where entries go and
+// how long they are kept.
+type QueryLogConfig struct {
+	Sink       string `json:"sink"`         // "file"(JSON lines, 默认) / "csv" / "syslog"
+	Path       string `json:"path"`         // file/csv sink 的输出路径
+	MaxSizeMB  int    `json:"max_size_mb"`  // file sink 超过该大小(MB)后轮转, 0 表示不按大小轮转
+	MaxAgeDays int    `json:"max_age_days"` // file sink 超过该天数后轮转, 0 表示不按时间轮转
+	RetainDays int    `json:"retain_days"`  // 清理超过该天数的轮转文件, 0 表示不清理
+	Listen     string `json:"listen"`       // 非空时启动 /querylog 调试接口, 例如 ":8053"
+}
+
+// ControlConfig configures the optional control HTTP API used to reload
+// config, inspect upstream health, and flush the cache without a restart.
+type ControlConfig struct {
+	Listen string `json:"listen"` // 非空时启动控制接口, 例如 ":8054"; 留空则不启动
 }
 
 type Config struct {
-	Servers []string `json:"servers"` // 转发请求
-	Domains []Domain `json:"domains"`
-	Cache   struct {
+	Servers       []string            `json:"servers"`     // 转发请求, 支持 udp://, tcp://, tls://, https://, quic:// 前缀, 无前缀按 udp 处理
+	Bootstrap     []string            `json:"bootstrap"`   // 解析 DoH/DoT 服务器域名用的引导 DNS; quic:// 上游不使用, 仍走系统解析
+	Strategy      string              `json:"strategy"`    // sequential(默认) / parallel_best / random_two
+	Conditional   map[string][]string `json:"conditional"` // 域名后缀 -> 专用上游列表, split-horizon 场景使用, 例如 "corp.local": ["udp://10.0.0.1:53"]
+	Blocking      BlockingConfig      `json:"blocking"`
+	Domains       []Domain            `json:"domains"`
+	EnableReverse bool                `json:"enable_reverse"` // 为每条本地 A/AAAA 记录自动合成 in-addr.arpa/ip6.arpa 的 PTR 查询
+	QueryLog      QueryLogConfig      `json:"query_log"`
+	Control       ControlConfig       `json:"control"`
+	Cache         struct {
 		TTL int `json:"ttl"`
 	} `json:"cache"`
 }
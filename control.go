@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startControl serves the control HTTP API configured via Config.Control,
+// and watches the config file for changes so edits take effect without a
+// restart:
+//
+//	POST   /reload                 re-read and atomically swap the config
+//	GET    /upstreams               per-upstream health and latency/error stats
+//	DELETE /cache                   flush the whole answer cache
+//	DELETE /cache/{name}/{type}     flush one cache entry, e.g. /cache/example.com./A
+//	GET    /stats                   query/cache counters
+//
+// reload() swaps the whole *Config, so Domains/Servers/Strategy take effect
+// immediately wherever handler code reads h.config(). Servers additionally
+// gets a live health-check pool within one watch() tick.
+// Conditional, Blocking, EnableReverse and QueryLog are only consulted once
+// in NewHandler to build h.conditional/h.blocking/h.reverse/h.queryLog, so
+// changes to those four sections are NOT picked up by /reload or the file
+// watcher and still require a restart.
+func (h *dnsHandler) startControl() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reload", h.handleReload)
+	mux.HandleFunc("/upstreams", h.handleUpstreams)
+	mux.HandleFunc("/cache", h.handleCache)
+	mux.HandleFunc("DELETE /cache/{name}/{type}", h.handleCacheEntry)
+	mux.HandleFunc("/stats", h.handleStats)
+
+	go h.watchConfigFile()
+
+	addr := h.config().Control.Listen
+	log.Println("control api listening on", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Println("control api stopped:", err)
+	}
+}
+
+// reload re-reads the config file at h.cfgPath and atomically swaps it in.
+// watch() picks up any Servers change on its next health-check tick.
+func (h *dnsHandler) reload() error {
+	cfg, err := loadConf(h.cfgPath)
+	if err != nil {
+		return err
+	}
+	h.cfg.Store(cfg)
+	log.Println("config reloaded from", h.cfgPath)
+	return nil
+}
+
+func (h *dnsHandler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type upstreamStatus struct {
+	Server    string  `json:"server"`
+	Dead      bool    `json:"dead"`
+	Failed    int     `json:"failed"`
+	Heartbeat int64   `json:"heartbeat"`
+	LatencyMS float64 `json:"latency_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func (h *dnsHandler) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	var statuses []upstreamStatus
+	h.upstream.Range(func(_, v any) bool {
+		up := v.(*upstream)
+		latency, errRate := up.stats()
+		statuses = append(statuses, upstreamStatus{
+			Server:    up.server,
+			Dead:      up.IsDead(),
+			Failed:    up.failed,
+			Heartbeat: up.heatbeat,
+			LatencyMS: float64(latency) / float64(time.Millisecond),
+			ErrorRate: errRate,
+		})
+		return true
+	})
+	writeJSON(w, statuses)
+}
+
+func (h *dnsHandler) handleCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cache.Range(func(key, _ any) bool {
+		cache.Delete(key)
+		return true
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCacheEntry flushes the single cache entry for DELETE /cache/{name}/{type},
+// building the same "name-qtype" key ServeDNS uses when populating the
+// cache, so a flushed name/type pair is re-resolved on the next query.
+func (h *dnsHandler) handleCacheEntry(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	typ := strings.ToUpper(r.PathValue("type"))
+	qtype, ok := typeMap[typ]
+	if !ok {
+		http.Error(w, "unknown record type: "+typ, http.StatusBadRequest)
+		return
+	}
+	cache.Delete(fmt.Sprintf("%s-%d", dns.Fqdn(name), qtype))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStats reports the lightweight JSON counters dnsHandler already
+// tracks (total queries, cache hits/ratio). It does not implement the
+// Prometheus text-exposition format or a qps/latency-histogram breakdown
+// that a full metrics endpoint would have — this is a reduced stats
+// endpoint, not a Prometheus exporter. Per-upstream latency/error-rate is
+// available separately via GET /upstreams.
+func (h *dnsHandler) handleStats(w http.ResponseWriter, r *http.Request) {
+	queries := h.queries.Load()
+	hits := h.cacheHits.Load()
+	hitRatio := 0.0
+	if queries > 0 {
+		hitRatio = float64(hits) / float64(queries)
+	}
+	writeJSON(w, map[string]any{
+		"queries":         queries,
+		"cache_hits":      hits,
+		"cache_hit_ratio": hitRatio,
+	})
+}
+
+// watchConfigFile polls h.cfgPath's mtime and triggers the same reload
+// logic as POST /reload whenever the file changes on disk, same polling
+// style as watch()'s upstream health checks.
+func (h *dnsHandler) watchConfigFile() {
+	var lastMod time.Time
+	if info, err := os.Stat(h.cfgPath); err == nil {
+		lastMod = info.ModTime()
+	}
+	for {
+		time.Sleep(2 * time.Second)
+		info, err := os.Stat(h.cfgPath)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().After(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+		if err := h.reload(); err != nil {
+			log.Println("config auto-reload failed:", err)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprintf("encode response: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqUpstream implements DNS-over-QUIC (DoQ, RFC 9250). Each query gets its
+// own bidirectional stream on a shared, lazily (re)dialed connection, as the
+// RFC requires.
+type doqUpstream struct {
+	server string
+	addr   string
+	tlsCfg *tls.Config
+
+	mu   sync.Mutex
+	conn quic.Connection
+}
+
+func newDoQUpstream(server string, bootstrap []string) (*doqUpstream, error) {
+	addr := strings.TrimPrefix(server, "quic://")
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		addr = net.JoinHostPort(addr, "853")
+	}
+	return &doqUpstream{
+		server: server,
+		addr:   addr,
+		tlsCfg: &tls.Config{ServerName: host, NextProtos: []string{"doq"}},
+	}, nil
+}
+
+func (u *doqUpstream) String() string { return u.server }
+
+func (u *doqUpstream) dial(ctx context.Context) (quic.Connection, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conn != nil {
+		select {
+		case <-u.conn.Context().Done():
+			u.conn = nil
+		default:
+			return u.conn, nil
+		}
+	}
+	conn, err := quic.DialAddr(ctx, u.addr, u.tlsCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.conn = conn
+	return conn, nil
+}
+
+func (u *doqUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	conn, err := u.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("doq dial %s: %w", u.server, err)
+	}
+
+	// RFC 9250 requires the query ID to be 0 on the wire for DoQ.
+	q := m.Copy()
+	q.Id = 0
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	prefixed := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(prefixed, uint16(len(packed)))
+	copy(prefixed[2:], packed)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	} else {
+		_ = stream.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+
+	if _, err := stream.Write(prefixed); err != nil {
+		return nil, err
+	}
+	_ = stream.Close()
+
+	var lenBuf [2]byte
+	if _, err := readFull(stream, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := readFull(stream, body); err != nil {
+		return nil, err
+	}
+
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, err
+	}
+	in.Id = m.Id
+	return in, nil
+}
+
+func readFull(r interface{ Read([]byte) (int, error) }, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := r.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
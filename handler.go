@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -37,6 +41,11 @@ var typeMap = map[string]uint16{
 	"CNAME": dns.TypeCNAME,
 	"MX":    dns.TypeMX,
 	"HTTPS": dns.TypeHTTPS,
+	"SRV":   dns.TypeSRV,
+	"NS":    dns.TypeNS,
+	"PTR":   dns.TypePTR,
+	"SOA":   dns.TypeSOA,
+	"CAA":   dns.TypeCAA,
 }
 var typeMapRev = map[uint16]string{}
 
@@ -52,52 +61,169 @@ func init() {
 	}()
 }
 
-func NewHandler(cfg *Config) (*dnsHandler, error) {
+func NewHandler(cfg *Config, cfgPath string) (*dnsHandler, error) {
 	v := &dnsHandler{
-		cfg: cfg,
-		pool: sync.Pool{New: func() any {
-			c := new(dns.Client)
-			c.Timeout = time.Second * 5
-			c.UDPSize = 65535
-			return c
-		}},
-		upstream: sync.Map{},
+		cfgPath:     cfgPath,
+		upstream:    sync.Map{},
+		conditional: buildConditionalUpstreams(cfg),
+		blocking:    newBlocklist(cfg.Blocking),
+		reverse:     buildReverseIndex(cfg),
+		queryLog:    newQueryLog(cfg.QueryLog),
 	}
+	v.cfg.Store(cfg)
 	go v.watch()
+	if cfg.Control.Listen != "" {
+		go v.startControl()
+	}
 	return v, nil
 }
 
+// buildConditionalUpstreams resolves Config.Conditional into ready-to-use
+// upstream pools, keyed by normalized (lowercase, no trailing dot) suffix.
+// Unlike the main pool these are not health-checked: split-horizon targets
+// are typically a handful of always-on internal resolvers.
+func buildConditionalUpstreams(cfg *Config) map[string][]*upstream {
+	conditional := make(map[string][]*upstream, len(cfg.Conditional))
+	for suffix, servers := range cfg.Conditional {
+		ups := make([]*upstream, 0, len(servers))
+		for _, server := range servers {
+			up, err := newUpstream(server, cfg.Bootstrap)
+			if err != nil {
+				log.Println("skip conditional upstream", suffix, server, err)
+				continue
+			}
+			ups = append(ups, &upstream{server: server, up: up})
+		}
+		conditional[strings.ToLower(strings.TrimSuffix(suffix, "."))] = ups
+	}
+	return conditional
+}
+
 type upstream struct {
 	server   string
+	up       Upstream
 	heatbeat int64
 	failed   int // 失败次数
+
+	statsMu     sync.Mutex
+	latencyEWMA time.Duration
+	errorEWMA   float64 // 0..1, 近期失败率的指数加权平均
 }
 
 func (s *upstream) IsDead() bool {
 	return s.failed > 20
 }
 
+// recordResult feeds one query outcome into the upstream's rolling stats.
+// The random_two strategy weights its picks by the error rate; the latency
+// average is only reported via GET /upstreams.
+func (s *upstream) recordResult(latency time.Duration, err error) {
+	const alpha = 0.2
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.latencyEWMA == 0 {
+		s.latencyEWMA = latency
+	} else {
+		s.latencyEWMA = time.Duration(float64(s.latencyEWMA)*(1-alpha) + float64(latency)*alpha)
+	}
+	outcome := 0.0
+	if err != nil {
+		outcome = 1.0
+	}
+	s.errorEWMA = s.errorEWMA*(1-alpha) + outcome*alpha
+}
+
+func (s *upstream) stats() (latency time.Duration, errRate float64) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.latencyEWMA, s.errorEWMA
+}
+
 type dnsHandler struct {
-	cfg      *Config
-	pool     sync.Pool
-	upstream sync.Map
+	cfgPath     string
+	cfg         atomic.Pointer[Config] // swapped atomically by /reload, see control.go
+	upstream    sync.Map
+	conditional map[string][]*upstream
+	blocking    *blocklist
+	reverse     map[string]string // in-addr.arpa/ip6.arpa name -> host FQDN, see buildReverseIndex
+	queryLog    *queryLog
+
+	queries   atomic.Int64
+	cacheHits atomic.Int64
 }
 
+// config returns the currently active Config. Reads are lock-free so a
+// reload never blocks query handling.
+func (h *dnsHandler) config() *Config {
+	return h.cfg.Load()
+}
+
+// matchConditional returns the upstream pool configured for the longest
+// domain suffix in Config.Conditional that matches name, or nil if no
+// suffix matches and the global pool should be used instead.
+func (h *dnsHandler) matchConditional(name string) []*upstream {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var bestSuffix string
+	var bestUps []*upstream
+	for suffix, ups := range h.conditional {
+		if name != suffix && !strings.HasSuffix(name, "."+suffix) {
+			continue
+		}
+		if len(suffix) > len(bestSuffix) {
+			bestSuffix = suffix
+			bestUps = ups
+		}
+	}
+	return bestUps
+}
+
+// watch probes every configured upstream every 10s, tracking per-upstream
+// health in h.upstream. An upstream that IsDead is no longer probed. It re-reads the live Config each tick, so a
+// /reload that adds or removes servers starts/stops their probes without a
+// restart.
 func (h *dnsHandler) watch() {
-	upstreams := make([]*upstream, 0, len(h.cfg.Servers))
-	for _, server := range h.cfg.Servers {
-		upstreams = append(upstreams, &upstream{
-			server: server,
-		})
+	known := map[string]*upstream{}
+
+	syncUpstreams := func(cfg *Config) []*upstream {
+		desired := make(map[string]struct{}, len(cfg.Servers))
+		for _, server := range cfg.Servers {
+			desired[server] = struct{}{}
+			if _, ok := known[server]; ok {
+				continue
+			}
+			up, err := newUpstream(server, cfg.Bootstrap)
+			if err != nil {
+				log.Println("skip upstream", server, err)
+				continue
+			}
+			known[server] = &upstream{server: server, up: up}
+			log.Println("upstream added", server)
+		}
+		for server, up := range known {
+			if _, ok := desired[server]; ok {
+				continue
+			}
+			delete(known, server)
+			h.upstream.Delete(up.server)
+			log.Println("upstream removed", server)
+		}
+		ups := make([]*upstream, 0, len(known))
+		for _, up := range known {
+			ups = append(ups, up)
+		}
+		return ups
 	}
-	c := h.pool.Get().(*dns.Client)
+
 	loop := func() {
+		upstreams := syncUpstreams(h.config())
 		for _, up := range upstreams {
 			if up.IsDead() {
 				log.Println("upstream check", up.server, "is dead")
 				continue
 			}
-			in, _, err := c.Exchange(&dns.Msg{
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			start := time.Now()
+			in, err := up.up.Exchange(ctx, &dns.Msg{
 				Question: []dns.Question{
 					{
 						Name:   "www.baidu.com.",
@@ -105,7 +231,9 @@ func (h *dnsHandler) watch() {
 						Qclass: dns.ClassINET,
 					},
 				},
-			}, up.server)
+			})
+			cancel()
+			up.recordResult(time.Since(start), err)
 			log.Println("upstream check", up.server, up.failed, err)
 			up.heatbeat = time.Now().Unix()
 			_, ok := h.upstream.Load(up.server)
@@ -126,34 +254,157 @@ func (h *dnsHandler) watch() {
 	}
 }
 
-func (h *dnsHandler) resolve(domain string, qtype uint16) []dns.RR {
+// healthyUpstreams snapshots the currently-alive upstreams. h.upstream is a
+// sync.Map only because entries are added/removed concurrently by watch();
+// the strategies below want an ordinary slice to fan out over.
+func (h *dnsHandler) healthyUpstreams() []*upstream {
+	ups := make([]*upstream, 0)
+	h.upstream.Range(func(_, value any) bool {
+		ups = append(ups, value.(*upstream))
+		return true
+	})
+	return ups
+}
+
+// resolve forwards domain/qtype upstream and returns the raw response
+// message, so the caller can inspect Rcode and the Ns (authority) section
+// for NXDOMAIN/NODATA handling, not just Answer. Returns nil if no upstream
+// could be reached at all.
+func (h *dnsHandler) resolve(ctx context.Context, domain string, qtype uint16) *dns.Msg {
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), qtype)
 	m.RecursionDesired = true
 
-	c := h.pool.Get().(*dns.Client)
-	in := []dns.RR{}
-	h.upstream.Range(func(key, value any) bool {
-		up := value.(*upstream)
-		{
-			rs, _, err := c.Exchange(m, up.server)
-			if err != nil {
-				log.Println(domain, qtype, err)
-				return true
-			}
-			for _, ans := range rs.Answer {
-				log.Println("  ", ans)
-			}
-			in = rs.Answer
-			return false
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ups := h.matchConditional(domain)
+	if ups == nil {
+		ups = h.healthyUpstreams()
+	}
+	if len(ups) == 0 {
+		return nil
+	}
+
+	switch h.config().Strategy {
+	case "parallel_best":
+		return resolveParallel(ctx, m, ups)
+	case "random_two":
+		return resolveParallel(ctx, m, pickWeightedTwo(ups))
+	default:
+		return resolveSequential(ctx, m, ups)
+	}
+}
+
+// resolveSequential is the original behaviour: try each upstream in turn and
+// return the first reply that didn't fail in transit.
+func resolveSequential(ctx context.Context, m *dns.Msg, ups []*upstream) *dns.Msg {
+	for _, up := range ups {
+		start := time.Now()
+		rs, err := up.up.Exchange(ctx, m)
+		up.recordResult(time.Since(start), err)
+		if err != nil {
+			log.Println(m.Question[0].Name, m.Question[0].Qtype, up.server, err)
+			continue
 		}
-	})
-	return in
+		for _, ans := range rs.Answer {
+			log.Println("  ", ans)
+		}
+		return rs
+	}
+	return nil
+}
+
+// resolveParallel fans the query out to every upstream in ups at once and
+// returns the first non-error reply, cancelling the rest.
+func resolveParallel(ctx context.Context, m *dns.Msg, ups []*upstream) *dns.Msg {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		up  *upstream
+		rs  *dns.Msg
+		err error
+		rtt time.Duration
+	}
+	resCh := make(chan result, len(ups))
+	for _, up := range ups {
+		up := up
+		go func() {
+			start := time.Now()
+			rs, err := up.up.Exchange(ctx, m)
+			resCh <- result{up: up, rs: rs, err: err, rtt: time.Since(start)}
+		}()
+	}
+
+	for i := 0; i < len(ups); i++ {
+		res := <-resCh
+		res.up.recordResult(res.rtt, res.err)
+		if res.err != nil || res.rs == nil {
+			log.Println(m.Question[0].Name, m.Question[0].Qtype, res.up.server, res.err)
+			continue
+		}
+		log.Println("fastest upstream", res.up.server, res.rtt)
+		return res.rs
+	}
+	return nil
 }
 
+// pickWeightedTwo picks two upstreams to race, weighted towards the ones
+// with the lowest recent error rate (blocky calls this parallel_best_resolver).
+func pickWeightedTwo(ups []*upstream) []*upstream {
+	if len(ups) <= 2 {
+		return ups
+	}
+	weights := make([]float64, len(ups))
+	total := 0.0
+	for i, up := range ups {
+		_, errRate := up.stats()
+		w := 1 - errRate
+		if w <= 0 {
+			w = 0.01
+		}
+		weights[i] = w
+		total += w
+	}
+	first := weightedPick(weights, total, -1)
+	second := weightedPick(weights, total, first)
+	picked := []*upstream{ups[first]}
+	if second >= 0 {
+		picked = append(picked, ups[second])
+	}
+	return picked
+}
+
+func weightedPick(weights []float64, total float64, exclude int) int {
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if i == exclude {
+			continue
+		}
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	for i := range weights {
+		if i != exclude {
+			return i
+		}
+	}
+	return -1
+}
+
+// errNXDomain means no locally-configured record name matches question.Name.
+// errNoData means a Domain owns the name but has no record of the queried
+// type (e.g. an A exists but AAAA was asked) — RFC 2308 NODATA, not NXDOMAIN.
+var errNXDomain = errors.New("nxdomain")
+var errNoData = errors.New("nodata")
+
 func (h *dnsHandler) match(question dns.Question) (*Record, error) {
 
-	for _, domain := range h.cfg.Domains {
+	nameMatched := false
+	for _, domain := range h.config().Domains {
 		if !strings.Contains(question.Name, domain.Name) {
 			continue
 		}
@@ -180,6 +431,7 @@ func (h *dnsHandler) match(question dns.Question) (*Record, error) {
 					}
 				}
 			}
+			nameMatched = true
 
 			t, ok := typeMap[r.Type]
 			if !ok {
@@ -194,14 +446,30 @@ func (h *dnsHandler) match(question dns.Question) (*Record, error) {
 			}
 		}
 	}
-	return nil, fmt.Errorf("not matched")
+	if nameMatched {
+		return nil, errNoData
+	}
+	return nil, errNXDomain
 }
 
 func (h *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-	handleLocal := func(question dns.Question) ([]dns.RR, bool) {
+	// handleLocal answers from locally-configured records. nameErr is only
+	// meaningful when ok is false: errNXDomain or errNoData, so the caller
+	// can tell "name doesn't exist" from "name exists, wrong type" per RFC 2308.
+	handleLocal := func(question dns.Question) (rrs []dns.RR, ok bool, nameErr error) {
+		if question.Qtype == dns.TypePTR {
+			if target, ok := h.reverse[strings.ToLower(question.Name)]; ok {
+				a := &dns.PTR{
+					Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 300},
+					Ptr: target,
+				}
+				return []dns.RR{a}, true, nil
+			}
+		}
+
 		r, err := h.match(question)
 		if err != nil {
-			return nil, false
+			return nil, false, err
 		}
 		switch typeMap[r.Type] {
 		case dns.TypeA:
@@ -209,26 +477,26 @@ func (h *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: r.TTL},
 				A:   net.ParseIP(r.Value),
 			}
-			return []dns.RR{a}, true
+			return []dns.RR{a}, true, nil
 		case dns.TypeAAAA:
 			a := &dns.AAAA{
 				Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: r.TTL},
 				AAAA: net.ParseIP(r.Value),
 			}
-			return []dns.RR{a}, true
+			return []dns.RR{a}, true, nil
 		case dns.TypeMX:
 			a := &dns.MX{
 				Hdr:        dns.RR_Header{Name: question.Name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: r.TTL},
 				Preference: r.Preference,
 				Mx:         r.Value,
 			}
-			return []dns.RR{a}, true
+			return []dns.RR{a}, true, nil
 		case dns.TypeCNAME:
 			a := &dns.CNAME{
 				Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: r.TTL},
 				Target: strings.TrimSuffix(r.Value, ".") + ".",
 			}
-			return []dns.RR{a}, true
+			return []dns.RR{a}, true, nil
 		case dns.TypeHTTPS:
 			a := new(dns.HTTPS)
 			a.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeHTTPS, Class: dns.ClassINET}
@@ -236,49 +504,162 @@ func (h *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			e.Alpn = strings.Split(r.Value, ",")
 			// []string{"h2", "http/1.1"}
 			a.Value = append(a.Value, e)
-			return []dns.RR{a}, true
+			return []dns.RR{a}, true, nil
+		case dns.TypeTXT:
+			a := &dns.TXT{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: r.TTL},
+				Txt: []string{r.Value},
+			}
+			return []dns.RR{a}, true, nil
+		case dns.TypeSRV:
+			a := &dns.SRV{
+				Hdr:      dns.RR_Header{Name: question.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: r.TTL},
+				Priority: r.Priority,
+				Weight:   r.Weight,
+				Port:     r.Port,
+				Target:   dns.Fqdn(r.Target),
+			}
+			return []dns.RR{a}, true, nil
+		case dns.TypeNS:
+			a := &dns.NS{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: r.TTL},
+				Ns:  dns.Fqdn(r.Ns),
+			}
+			return []dns.RR{a}, true, nil
+		case dns.TypePTR:
+			a := &dns.PTR{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: r.TTL},
+				Ptr: dns.Fqdn(r.Ptr),
+			}
+			return []dns.RR{a}, true, nil
+		case dns.TypeSOA:
+			if r.SOA == nil {
+				log.Println("soa record missing soa fields: " + question.String())
+				return nil, false, nil
+			}
+			return []dns.RR{synthSOA(question.Name, r.SOA)}, true, nil
+		case dns.TypeCAA:
+			a := &dns.CAA{
+				Hdr:   dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: r.TTL},
+				Flag:  r.Flag,
+				Tag:   r.Tag,
+				Value: r.Value,
+			}
+			return []dns.RR{a}, true, nil
 		default:
 			log.Println("invalid type: " + question.String())
 		}
 
-		return nil, false
+		return nil, false, nil
 	}
 
 	msg := new(dns.Msg)
 	msg.SetReply(r)
 	msg.Authoritative = true
 	for _, question := range r.Question {
-		log.Println(question.String())
-		log.Printf("Received query: %s, remote=%s\n", question.String(), w.RemoteAddr().String())
+		start := time.Now()
+		h.queries.Add(1)
+		entry := QueryLogEntry{
+			Time:   start,
+			Client: clientIP(w.RemoteAddr()),
+			Name:   question.Name,
+			Qtype:  typeMapRev[question.Qtype],
+		}
 
 		cacheKey := fmt.Sprintf("%s-%d", question.Name, question.Qtype)
 
-		v, ok := cache.Load(cacheKey)
-
-		if ok {
-			cacheValue := v.(cacheItem).value.([]dns.RR)
-			log.Println("from cache", cacheKey)
-			msg.Answer = append(msg.Answer, cacheValue...)
-			if len(cacheValue) > 0 {
+		if v, ok := cache.Load(cacheKey); ok {
+			h.cacheHits.Add(1)
+			entry.CacheHit = true
+			entry.Source = "cache"
+			switch cached := v.(cacheItem).value.(type) {
+			case []dns.RR:
+				msg.Answer = append(msg.Answer, cached...)
+				entry.Answers = len(cached)
+				entry.Rcode = dns.RcodeToString[dns.RcodeSuccess]
+				entry.RTT = time.Since(start)
+				h.queryLog.log(entry)
+				continue
+			case negativeEntry:
+				msg.Rcode = cached.rcode
+				msg.Ns = append(msg.Ns, cached.ns...)
+				entry.Rcode = dns.RcodeToString[cached.rcode]
+				entry.RTT = time.Since(start)
+				h.queryLog.log(entry)
 				continue
 			}
 		}
-		var answers []dns.RR
-		if answers, ok = handleLocal(question); !ok {
-			answers = h.resolve(question.Name, question.Qtype)
+
+		answers, ok, nameErr := handleLocal(question)
+		switch {
+		case ok:
+			entry.Source = "local"
+		case h.blocking.isBlocked(question.Name):
+			entry.Source = "blocked"
+			if bt := h.blocking.cfg.BlockType; bt == blockTypeZeroIP || bt == blockTypeCustomIP {
+				answers = h.blocking.respond(question)
+			} else {
+				msg.Rcode = dns.RcodeNameError
+				msg.Ns = append(msg.Ns, synthSOA(question.Name, h.blocking.soa()))
+				cache.Store(cacheKey, cacheItem{
+					expire: time.Now().Unix() + int64(h.blocking.soa().Minimum),
+					value:  negativeEntry{rcode: msg.Rcode, ns: msg.Ns},
+				})
+			}
+		default:
+			if soa, zone := domainSOA(h.config(), question.Name); soa != nil {
+				entry.Source = "local"
+				if nameErr == errNoData {
+					msg.Rcode = dns.RcodeSuccess // NODATA: name exists, no record of this type
+				} else {
+					msg.Rcode = dns.RcodeNameError
+				}
+				msg.Ns = append(msg.Ns, synthSOA(zone, soa))
+				cache.Store(cacheKey, cacheItem{
+					expire: time.Now().Unix() + int64(soa.Minimum),
+					value:  negativeEntry{rcode: msg.Rcode, ns: msg.Ns},
+				})
+			} else if rs := h.resolve(context.Background(), question.Name, question.Qtype); rs != nil {
+				entry.Source = "upstream"
+				answers = rs.Answer
+				switch {
+				case rs.Rcode == dns.RcodeNameError:
+					msg.Rcode = dns.RcodeNameError
+					msg.Ns = append(msg.Ns, rs.Ns...)
+					if ttl, ok := soaMinTTL(rs.Ns); ok {
+						cache.Store(cacheKey, cacheItem{
+							expire: time.Now().Unix() + int64(ttl),
+							value:  negativeEntry{rcode: msg.Rcode, ns: msg.Ns},
+						})
+					}
+				case rs.Rcode == dns.RcodeSuccess && len(rs.Answer) == 0:
+					// NODATA: NOERROR with an empty Answer. Copy any SOA upstream
+					// sent and negative-cache it the same as NXDOMAIN.
+					msg.Ns = append(msg.Ns, rs.Ns...)
+					if ttl, ok := soaMinTTL(rs.Ns); ok {
+						cache.Store(cacheKey, cacheItem{
+							expire: time.Now().Unix() + int64(ttl),
+							value:  negativeEntry{rcode: msg.Rcode, ns: msg.Ns},
+						})
+					}
+				}
+			}
 		}
 		if len(answers) > 0 {
 			ttl := int64(answers[0].Header().Ttl)
-			log.Println("save cache:", cacheKey, "ttl", ttl)
 			cache.Store(cacheKey, cacheItem{
 				expire: time.Now().Unix() + ttl,
 				value:  answers,
 			})
 		}
 		msg.Answer = append(msg.Answer, answers...)
+
+		entry.Answers = len(answers)
+		entry.Rcode = dns.RcodeToString[msg.Rcode]
+		entry.RTT = time.Since(start)
+		h.queryLog.log(entry)
 	}
-	err := w.WriteMsg(msg)
-	if err != nil {
+	if err := w.WriteMsg(msg); err != nil {
 		log.Printf("write response error: %s", err.Error())
 	}
 }
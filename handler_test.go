@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestMatchConditional(t *testing.T) {
+	corpUps := []*upstream{{server: "udp://10.0.0.1:53"}}
+	deepUps := []*upstream{{server: "udp://10.0.0.2:53"}}
+
+	h := &dnsHandler{
+		conditional: map[string][]*upstream{
+			"corp.local":     corpUps,
+			"a.b.corp.local": deepUps,
+		},
+	}
+
+	tests := []struct {
+		name string
+		want []*upstream
+	}{
+		{"host.corp.local.", corpUps},
+		{"corp.local.", corpUps},
+		{"x.a.b.corp.local.", deepUps}, // longest matching suffix wins
+		{"other.example.com.", nil},
+	}
+
+	for _, tt := range tests {
+		got := h.matchConditional(tt.name)
+		if len(got) != len(tt.want) {
+			t.Errorf("matchConditional(%q) = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("matchConditional(%q)[%d] = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -23,7 +23,7 @@ func main() {
 		panic(err)
 	}
 
-	handler, err := NewHandler(cfg)
+	handler, err := NewHandler(cfg, config)
 	if err != nil {
 		panic(err)
 	}
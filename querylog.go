@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// clientIP strips the ephemeral port from addr.String() so QueryLogEntry.Client
+// holds a plain IP, which is what an operator can actually type into
+// /querylog?client=.
+func clientIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// QueryLogEntry is one structured record of a processed DNS query.
+type QueryLogEntry struct {
+	Time     time.Time     `json:"time"`
+	Client   string        `json:"client"`
+	Name     string        `json:"name"`
+	Qtype    string        `json:"qtype"`
+	Rcode    string        `json:"rcode"`
+	Answers  int           `json:"answers"`
+	CacheHit bool          `json:"cache_hit"`
+	Source   string        `json:"source"` // local / blocked / upstream / cache
+	RTT      time.Duration `json:"rtt,omitempty"`
+}
+
+// querySink is where query log entries ultimately land.
+type querySink interface {
+	write(QueryLogEntry) error
+}
+
+// pruner is implemented by sinks that keep rotated files around and need
+// periodic cleanup of entries older than QueryLogConfig.RetainDays.
+type pruner interface {
+	prune(days int)
+}
+
+const (
+	queryLogBufferSize  = 1024
+	queryLogRecentLimit = 1000
+)
+
+// queryLog buffers entries on a channel and hands them to a sink from a
+// single background goroutine, so a slow sink (disk, syslog) never adds
+// latency to the DNS hot path. ServeDNS calls log(), never write() directly.
+type queryLog struct {
+	cfg     QueryLogConfig
+	entries chan QueryLogEntry
+	sink    querySink
+
+	mu     sync.Mutex
+	recent []QueryLogEntry // small ring buffer backing the /querylog endpoint
+}
+
+func newQueryLog(cfg QueryLogConfig) *queryLog {
+	ql := &queryLog{
+		cfg:     cfg,
+		entries: make(chan QueryLogEntry, queryLogBufferSize),
+	}
+	sink, err := newQuerySink(cfg)
+	if err != nil {
+		log.Println("querylog: sink disabled:", err)
+	}
+	ql.sink = sink
+	go ql.run()
+	if cfg.Listen != "" {
+		go ql.serveHTTP()
+	}
+	return ql
+}
+
+// newQuerySink picks the sink for cfg.Sink. An unset Sink defaults to "file"
+// when a Path is configured, matching QueryLogConfig's documented default;
+// with neither set there is nowhere to write, so logging stays disabled.
+func newQuerySink(cfg QueryLogConfig) (querySink, error) {
+	sink := cfg.Sink
+	if sink == "" {
+		if cfg.Path == "" {
+			return nil, nil
+		}
+		sink = "file"
+	}
+	switch sink {
+	case "none":
+		return nil, nil
+	case "file":
+		return newFileSink(cfg)
+	case "csv":
+		return newCSVSink(cfg)
+	case "syslog":
+		return newSyslogSink()
+	default:
+		return nil, fmt.Errorf("unknown query log sink: %s", sink)
+	}
+}
+
+func (ql *queryLog) run() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-ql.entries:
+			ql.remember(e)
+			if ql.sink == nil {
+				continue
+			}
+			if err := ql.sink.write(e); err != nil {
+				log.Println("querylog: write:", err)
+			}
+		case <-ticker.C:
+			if ql.cfg.RetainDays <= 0 {
+				continue
+			}
+			if p, ok := ql.sink.(pruner); ok {
+				p.prune(ql.cfg.RetainDays)
+			}
+		}
+	}
+}
+
+func (ql *queryLog) remember(e QueryLogEntry) {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	ql.recent = append(ql.recent, e)
+	if len(ql.recent) > queryLogRecentLimit {
+		ql.recent = ql.recent[len(ql.recent)-queryLogRecentLimit:]
+	}
+}
+
+// log enqueues e without blocking the caller; if the buffer is full the
+// entry is dropped rather than stalling a DNS response.
+func (ql *queryLog) log(e QueryLogEntry) {
+	select {
+	case ql.entries <- e:
+	default:
+		log.Println("querylog: buffer full, dropping entry for", e.Name)
+	}
+}
+
+func (ql *queryLog) serveHTTP() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/querylog", ql.handleHTTP)
+	log.Println("querylog: http endpoint on", ql.cfg.Listen)
+	if err := http.ListenAndServe(ql.cfg.Listen, mux); err != nil {
+		log.Println("querylog: http endpoint stopped:", err)
+	}
+}
+
+// handleHTTP streams recent entries as newline-delimited JSON, optionally
+// filtered by ?since=<RFC3339> and/or ?client=<ip>.
+func (ql *queryLog) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		if parsed, err := time.Parse(time.RFC3339, s); err == nil {
+			since = parsed
+		}
+	}
+	client := r.URL.Query().Get("client")
+
+	ql.mu.Lock()
+	entries := make([]QueryLogEntry, len(ql.recent))
+	copy(entries, ql.recent)
+	ql.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		if client != "" && e.Client != client {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+	}
+}
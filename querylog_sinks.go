@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileSink writes one JSON object per line, rotating the file by size
+// and/or age as configured.
+type fileSink struct {
+	cfg QueryLogConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	opened time.Time
+	size   int64
+}
+
+func newFileSink(cfg QueryLogConfig) (*fileSink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("file query log sink requires a path")
+	}
+	s := &fileSink{cfg: cfg}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.writer = bufio.NewWriter(f)
+	s.opened = time.Now()
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) write(e QueryLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.needsRotation() {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+	n, err := s.writer.Write(body)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+func (s *fileSink) needsRotation() bool {
+	if s.cfg.MaxSizeMB > 0 && s.size >= int64(s.cfg.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if s.cfg.MaxAgeDays > 0 && time.Since(s.opened) >= time.Duration(s.cfg.MaxAgeDays)*24*time.Hour {
+		return true
+	}
+	return false
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	s.file.Close()
+	rotated := fmt.Sprintf("%s.%s", s.cfg.Path, time.Now().Format("20060102T150405"))
+	if err := os.Rename(s.cfg.Path, rotated); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// prune deletes rotated files that were last modified more than days ago. The live file currently being written is never touched.
+func (s *fileSink) prune(days int) {
+	dir := filepath.Dir(s.cfg.Path)
+	base := filepath.Base(s.cfg.Path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		os.Remove(filepath.Join(dir, name))
+	}
+}
+
+// csvSink appends one row per query to a plain CSV file.
+type csvSink struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+}
+
+var csvHeader = []string{"time", "client", "name", "qtype", "rcode", "answers", "cache_hit", "source", "rtt_ms"}
+
+func newCSVSink(cfg QueryLogConfig) (*csvSink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("csv query log sink requires a path")
+	}
+	_, statErr := os.Stat(cfg.Path)
+	needsHeader := os.IsNotExist(statErr)
+
+	f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &csvSink{file: f, writer: csv.NewWriter(f)}
+	if needsHeader {
+		if err := s.writer.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		s.writer.Flush()
+	}
+	return s, nil
+}
+
+func (s *csvSink) write(e QueryLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row := []string{
+		e.Time.Format(time.RFC3339),
+		e.Client,
+		e.Name,
+		e.Qtype,
+		e.Rcode,
+		strconv.Itoa(e.Answers),
+		strconv.FormatBool(e.CacheHit),
+		e.Source,
+		strconv.FormatInt(e.RTT.Milliseconds(), 10),
+	}
+	if err := s.writer.Write(row); err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogSink forwards each entry as one JSON-encoded syslog/journald message.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink() (*syslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "adns")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) write(e QueryLogEntry) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(body))
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "fmt"
+
+// syslogSink is unavailable on windows; log/syslog only supports unix.
+type syslogSink struct{}
+
+func newSyslogSink() (*syslogSink, error) {
+	return nil, fmt.Errorf("syslog query log sink is not supported on windows")
+}
+
+func (s *syslogSink) write(QueryLogEntry) error { return nil }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// buildReverseIndex synthesizes a PTR lookup table from every locally
+// configured A/AAAA record, keyed by its in-addr.arpa/ip6.arpa name, when
+// Config.EnableReverse is set. This lets a host defined only as an A record
+// resolve in reverse without a separate PTR entry.
+func buildReverseIndex(cfg *Config) map[string]string {
+	if !cfg.EnableReverse {
+		return nil
+	}
+	idx := make(map[string]string)
+	for _, domain := range cfg.Domains {
+		for _, r := range domain.Records {
+			if r.Type != "A" && r.Type != "AAAA" {
+				continue
+			}
+			if net.ParseIP(r.Value) == nil {
+				continue
+			}
+			arpa, err := dns.ReverseAddr(r.Value)
+			if err != nil {
+				continue
+			}
+			idx[strings.ToLower(arpa)] = fmt.Sprintf("%s.%s.", r.Name, domain.Name)
+		}
+	}
+	return idx
+}
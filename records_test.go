@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBuildReverseIndex(t *testing.T) {
+	cfg := &Config{
+		EnableReverse: true,
+		Domains: []Domain{
+			{
+				Name: "example.com",
+				Records: []Record{
+					{Name: "www", Type: "A", Value: "192.0.2.1"},
+					{Name: "www", Type: "AAAA", Value: "2001:db8::1"},
+					{Name: "www", Type: "TXT", Value: "not an address, ignored"},
+					{Name: "bad", Type: "A", Value: "not-an-ip"},
+				},
+			},
+		},
+	}
+
+	idx := buildReverseIndex(cfg)
+
+	arpaV4, _ := dns.ReverseAddr("192.0.2.1")
+	if got := idx[arpaV4]; got != "www.example.com." {
+		t.Errorf("A record: idx[%s] = %q, want %q", arpaV4, got, "www.example.com.")
+	}
+
+	arpaV6, _ := dns.ReverseAddr("2001:db8::1")
+	if got := idx[arpaV6]; got != "www.example.com." {
+		t.Errorf("AAAA record: idx[%s] = %q, want %q", arpaV6, got, "www.example.com.")
+	}
+
+	if len(idx) != 2 {
+		t.Errorf("len(idx) = %d, want 2 (TXT and unparsable A must be skipped)", len(idx))
+	}
+}
+
+func TestBuildReverseIndexDisabled(t *testing.T) {
+	cfg := &Config{
+		EnableReverse: false,
+		Domains: []Domain{
+			{Name: "example.com", Records: []Record{{Name: "www", Type: "A", Value: "192.0.2.1"}}},
+		},
+	}
+	if idx := buildReverseIndex(cfg); idx != nil {
+		t.Errorf("buildReverseIndex with EnableReverse=false = %v, want nil", idx)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeUpstream implements Upstream for testing resolveSequential/
+// resolveParallel without any real network I/O.
+type fakeUpstream struct {
+	name  string
+	delay time.Duration
+	err   error
+	reply *dns.Msg
+}
+
+func (f *fakeUpstream) String() string { return f.name }
+
+func (f *fakeUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.reply, nil
+}
+
+func fakeReply() *dns.Msg {
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}}}
+	return m
+}
+
+func newQuestion() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	return m
+}
+
+func TestResolveSequential(t *testing.T) {
+	want := fakeReply()
+
+	tests := []struct {
+		name    string
+		ups     []*upstream
+		wantNil bool
+	}{
+		{
+			name: "first upstream fails, second succeeds",
+			ups: []*upstream{
+				{server: "a", up: &fakeUpstream{name: "a", err: errors.New("refused")}},
+				{server: "b", up: &fakeUpstream{name: "b", reply: want}},
+			},
+		},
+		{
+			name: "all upstreams fail",
+			ups: []*upstream{
+				{server: "a", up: &fakeUpstream{name: "a", err: errors.New("refused")}},
+				{server: "b", up: &fakeUpstream{name: "b", err: errors.New("timeout")}},
+			},
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := resolveSequential(context.Background(), newQuestion(), tt.ups)
+			if tt.wantNil {
+				if rs != nil {
+					t.Fatalf("resolveSequential() = %v, want nil", rs)
+				}
+				return
+			}
+			if rs != want {
+				t.Fatalf("resolveSequential() = %v, want the reply from the succeeding upstream", rs)
+			}
+		})
+	}
+}
+
+func TestResolveParallel(t *testing.T) {
+	want := fakeReply()
+
+	tests := []struct {
+		name    string
+		ups     []*upstream
+		wantNil bool
+	}{
+		{
+			name: "fastest non-error reply wins",
+			ups: []*upstream{
+				{server: "slow", up: &fakeUpstream{name: "slow", delay: 30 * time.Millisecond, reply: fakeReply()}},
+				{server: "fast", up: &fakeUpstream{name: "fast", reply: want}},
+			},
+		},
+		{
+			name: "errors are skipped in favour of a later success",
+			ups: []*upstream{
+				{server: "bad", up: &fakeUpstream{name: "bad", err: errors.New("refused")}},
+				{server: "good", up: &fakeUpstream{name: "good", delay: 5 * time.Millisecond, reply: want}},
+			},
+		},
+		{
+			name: "all upstreams fail",
+			ups: []*upstream{
+				{server: "a", up: &fakeUpstream{name: "a", err: errors.New("refused")}},
+				{server: "b", up: &fakeUpstream{name: "b", err: errors.New("timeout")}},
+			},
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := resolveParallel(context.Background(), newQuestion(), tt.ups)
+			if tt.wantNil {
+				if rs != nil {
+					t.Fatalf("resolveParallel() = %v, want nil", rs)
+				}
+				return
+			}
+			if rs != want {
+				t.Fatalf("resolveParallel() = %v, want the reply from the fastest succeeding upstream", rs)
+			}
+		})
+	}
+}
+
+func TestWeightedPick(t *testing.T) {
+	// The excluded index must never be picked, even when it carries almost
+	// all of the total weight.
+	weights := []float64{0.01, 1.0, 0.01}
+	total := 1.02
+	for i := 0; i < 20; i++ {
+		got := weightedPick(weights, total, 1)
+		if got == 1 {
+			t.Fatalf("weightedPick() returned the excluded index")
+		}
+	}
+
+	if got := weightedPick(nil, 0, -1); got != -1 {
+		t.Errorf("weightedPick(nil) = %d, want -1", got)
+	}
+
+	if got := weightedPick([]float64{1.0}, 1.0, 0); got != -1 {
+		t.Errorf("weightedPick with only the excluded index left = %d, want -1", got)
+	}
+}
+
+func TestPickWeightedTwo(t *testing.T) {
+	up1 := &upstream{server: "a"}
+	up1.recordResult(time.Millisecond, nil)
+	up2 := &upstream{server: "b"}
+	up2.recordResult(time.Millisecond, nil)
+
+	t.Run("two or fewer upstreams returned unchanged", func(t *testing.T) {
+		ups := []*upstream{up1, up2}
+		got := pickWeightedTwo(ups)
+		if len(got) != 2 {
+			t.Fatalf("pickWeightedTwo() = %v, want the same 2 upstreams", got)
+		}
+	})
+
+	t.Run("more than two picks exactly two distinct upstreams", func(t *testing.T) {
+		up3 := &upstream{server: "c"}
+		up3.recordResult(time.Millisecond, nil)
+		ups := []*upstream{up1, up2, up3}
+		got := pickWeightedTwo(ups)
+		if len(got) != 2 {
+			t.Fatalf("pickWeightedTwo() = %v, want 2 upstreams", got)
+		}
+		if got[0] == got[1] {
+			t.Fatalf("pickWeightedTwo() picked the same upstream twice: %v", got)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// negativeEntry is what gets cached for an NXDOMAIN/NODATA answer: the
+// response code and its authority (SOA) section, so a repeat query can be
+// answered straight from cache instead of hitting upstream again.
+type negativeEntry struct {
+	rcode int
+	ns    []dns.RR
+}
+
+// domainSOA finds the longest configured Domain suffix that owns name and
+// returns its synthetic SOA, if one is set. A non-nil result means name
+// falls inside a zone we are authoritative for, even though no record
+// matched it.
+func domainSOA(cfg *Config, name string) (*SOA, string) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var best *Domain
+	for i := range cfg.Domains {
+		d := &cfg.Domains[i]
+		suffix := strings.ToLower(d.Name)
+		if name != suffix && !strings.HasSuffix(name, "."+suffix) {
+			continue
+		}
+		if best == nil || len(suffix) > len(best.Name) {
+			best = d
+		}
+	}
+	if best == nil || best.SOA == nil {
+		return nil, ""
+	}
+	return best.SOA, best.Name
+}
+
+// soaMinTTL returns the MINIMUM field of the first SOA found in ns, per
+// RFC 2308 the upper bound for caching a negative answer.
+func soaMinTTL(ns []dns.RR) (uint32, bool) {
+	for _, rr := range ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Minttl, true
+		}
+	}
+	return 0, false
+}
+
+// synthSOA builds the SOA RR served in the Authority section for a
+// locally-authoritative negative answer.
+func synthSOA(zone string, soa *SOA) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soa.Minimum},
+		Ns:      dns.Fqdn(soa.Ns),
+		Mbox:    dns.Fqdn(soa.Mbox),
+		Serial:  soa.Serial,
+		Refresh: soa.Refresh,
+		Retry:   soa.Retry,
+		Expire:  soa.Expire,
+		Minttl:  soa.Minimum,
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func testSOA() *SOA {
+	return &SOA{
+		Ns:      "ns1.example.com.",
+		Mbox:    "hostmaster.example.com.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minimum: 300,
+	}
+}
+
+func TestDomainSOA(t *testing.T) {
+	cfg := &Config{
+		Domains: []Domain{
+			{Name: "example.com", SOA: testSOA()},
+			{Name: "corp.example.com", SOA: &SOA{Ns: "ns1.corp.example.com.", Minimum: 60}},
+			{Name: "nosoa.example.com"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantZone string
+		wantNil  bool
+	}{
+		{name: "exact apex match", query: "example.com.", wantZone: "example.com"},
+		{name: "subdomain match", query: "www.example.com.", wantZone: "example.com"},
+		{name: "longest suffix wins", query: "host.corp.example.com.", wantZone: "corp.example.com"},
+		{name: "domain without SOA", query: "nosoa.example.com.", wantNil: true},
+		{name: "no owning domain", query: "other.org.", wantNil: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			soa, zone := domainSOA(cfg, tt.query)
+			if tt.wantNil {
+				if soa != nil {
+					t.Errorf("domainSOA(%q) = %v, want nil", tt.query, soa)
+				}
+				return
+			}
+			if soa == nil {
+				t.Fatalf("domainSOA(%q) = nil, want non-nil", tt.query)
+			}
+			if zone != tt.wantZone {
+				t.Errorf("domainSOA(%q) zone = %q, want %q", tt.query, zone, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestSynthSOA(t *testing.T) {
+	soa := testSOA()
+	rr := synthSOA("example.com", soa)
+
+	if rr.Hdr.Name != "example.com." {
+		t.Errorf("Hdr.Name = %q, want %q", rr.Hdr.Name, "example.com.")
+	}
+	if rr.Hdr.Rrtype != dns.TypeSOA || rr.Hdr.Class != dns.ClassINET {
+		t.Errorf("Hdr = %+v, want SOA/IN", rr.Hdr)
+	}
+	if rr.Hdr.Ttl != soa.Minimum {
+		t.Errorf("Hdr.Ttl = %d, want Minimum %d", rr.Hdr.Ttl, soa.Minimum)
+	}
+	if rr.Ns != soa.Ns || rr.Mbox != soa.Mbox {
+		t.Errorf("Ns/Mbox = %q/%q, want %q/%q", rr.Ns, rr.Mbox, soa.Ns, soa.Mbox)
+	}
+	if rr.Minttl != soa.Minimum {
+		t.Errorf("Minttl = %d, want %d", rr.Minttl, soa.Minimum)
+	}
+}
+
+func TestSoaMinTTL(t *testing.T) {
+	soa := synthSOA("example.com", testSOA())
+
+	if ttl, ok := soaMinTTL([]dns.RR{soa}); !ok || ttl != testSOA().Minimum {
+		t.Errorf("soaMinTTL with SOA present = (%d, %v), want (%d, true)", ttl, ok, testSOA().Minimum)
+	}
+	if _, ok := soaMinTTL([]dns.RR{&dns.A{}}); ok {
+		t.Error("soaMinTTL with no SOA present: ok = true, want false")
+	}
+	if _, ok := soaMinTTL(nil); ok {
+		t.Error("soaMinTTL(nil): ok = true, want false")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream abstracts a single forwarding target so dnsHandler does not need
+// to care whether it talks plain UDP/TCP, DoT, DoH or DoQ to reach it.
+type Upstream interface {
+	// Exchange sends m to the upstream and returns its reply.
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+	// String returns the configured server address, used for logging.
+	String() string
+}
+
+// bootstrapDialer returns a DialContext that resolves hostnames against the
+// configured bootstrap servers instead of the system resolver. This is what
+// lets a DoH/DoT entry such as "https://dns.google/dns-query" be reached
+// before any "real" resolver is up.
+func bootstrapDialer(bootstrap []string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if len(bootstrap) == 0 {
+		return dialer.DialContext
+	}
+	dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var lastErr error
+			for _, b := range bootstrap {
+				_, _, err := net.SplitHostPort(b)
+				addr := b
+				if err != nil {
+					addr = net.JoinHostPort(b, "53")
+				}
+				conn, dialErr := (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, "udp", addr)
+				if dialErr == nil {
+					return conn, nil
+				}
+				lastErr = dialErr
+			}
+			return nil, lastErr
+		},
+	}
+	return dialer.DialContext
+}
+
+// newUpstream parses a server entry such as "udp://1.1.1.1:53",
+// "tls://1.1.1.1:853" or "https://dns.google/dns-query" and builds the
+// matching Upstream implementation. Entries with no scheme keep the
+// historical plain-UDP behaviour.
+func newUpstream(server string, bootstrap []string) (Upstream, error) {
+	scheme, rest, ok := strings.Cut(server, "://")
+	if !ok {
+		scheme, rest = "udp", server
+	}
+	switch scheme {
+	case "udp", "tcp":
+		return &dnsUpstream{server: server, addr: rest, net: scheme}, nil
+	case "tls":
+		host, _, err := net.SplitHostPort(rest)
+		if err != nil {
+			host = rest
+		}
+		return &dnsUpstream{
+			server: server,
+			addr:   rest,
+			net:    "tcp-tls",
+			dialer: &net.Dialer{Timeout: 5 * time.Second, Resolver: bootstrapResolver(bootstrap)},
+			tlsCfg: &tls.Config{ServerName: host},
+		}, nil
+	case "https":
+		return newDoHUpstream(server, bootstrap)
+	case "quic":
+		return newDoQUpstream(server, bootstrap)
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme: %s", scheme)
+	}
+}
+
+func bootstrapResolver(bootstrap []string) *net.Resolver {
+	if len(bootstrap) == 0 {
+		return nil
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			addr := bootstrap[0]
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				addr = net.JoinHostPort(addr, "53")
+			}
+			return (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, "udp", addr)
+		},
+	}
+}
+
+// dnsUpstream talks plain DNS over udp/tcp, or DoT over tcp-tls, using the
+// standard miekg/dns client.
+type dnsUpstream struct {
+	server string
+	addr   string
+	net    string
+	dialer *net.Dialer
+	tlsCfg *tls.Config
+}
+
+func (u *dnsUpstream) String() string { return u.server }
+
+func (u *dnsUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{
+		Net:       u.net,
+		Timeout:   5 * time.Second,
+		TLSConfig: u.tlsCfg,
+	}
+	if u.dialer != nil {
+		c.Dialer = u.dialer
+	}
+	in, _, err := c.ExchangeContext(ctx, m, u.addr)
+	return in, err
+}
+
+// dohUpstream implements DNS-over-HTTPS, RFC 8484, using the wire format
+// over both GET and POST.
+type dohUpstream struct {
+	server string
+	url    string
+	client *http.Client
+}
+
+func newDoHUpstream(server string, bootstrap []string) (*dohUpstream, error) {
+	if _, err := url.Parse(server); err != nil {
+		return nil, fmt.Errorf("invalid DoH url %q: %w", server, err)
+	}
+	return &dohUpstream{
+		server: server,
+		url:    server,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				DialContext: bootstrapDialer(bootstrap),
+			},
+		},
+	}, nil
+}
+
+func (u *dohUpstream) String() string { return u.server }
+
+func (u *dohUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 8484 prefers GET for cacheability, but falls back to POST when the
+	// query, base64url-encoded, would make the URL unreasonably long.
+	var req *http.Request
+	encoded := base64.RawURLEncoding.EncodeToString(packed)
+	if len(encoded) <= 512 {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.url+"?dns="+encoded, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(packed))
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "application/dns-message")
+	if req.Method == http.MethodPost {
+		req.Header.Set("content-type", "application/dns-message")
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh upstream %s: unexpected status %s", u.server, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, err
+	}
+	return in, nil
+}